@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultLabelCacheSize bounds the in-memory LRU fallback used when
+// REDIS_URL is not configured.
+const defaultLabelCacheSize = 1000
+
+// defaultLabelCacheTTL is how long a Vision label result stays cached.
+const defaultLabelCacheTTL = 30 * 24 * time.Hour
+
+// LabelCache caches Vision label results (with their confidence scores) for
+// a previously seen image, keyed by the SHA-256 hash of its bytes.
+type LabelCache interface {
+	Get(ctx context.Context, hash string) ([]Candidate, bool)
+	Put(ctx context.Context, hash string, labels []Candidate, ttl time.Duration)
+}
+
+// newLabelCache builds a LabelCache backed by Redis when REDIS_URL is set,
+// falling back to an in-memory LRU otherwise.
+func newLabelCache() LabelCache {
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		opt, err := redis.ParseURL(url)
+		if err != nil {
+			log.Printf("error parsing REDIS_URL, falling back to in-memory cache: %v", err)
+			return newLRULabelCache(defaultLabelCacheSize)
+		}
+		return &redisLabelCache{client: redis.NewClient(opt)}
+	}
+	return newLRULabelCache(defaultLabelCacheSize)
+}
+
+type redisLabelCache struct {
+	client *redis.Client
+}
+
+func (c *redisLabelCache) Get(ctx context.Context, hash string) ([]Candidate, bool) {
+	raw, err := c.client.Get(ctx, labelCacheKey(hash)).Result()
+	if err != nil {
+		return nil, false
+	}
+	var labels []Candidate
+	if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+		return nil, false
+	}
+	return labels, true
+}
+
+func (c *redisLabelCache) Put(ctx context.Context, hash string, labels []Candidate, ttl time.Duration) {
+	raw, err := json.Marshal(labels)
+	if err != nil {
+		return
+	}
+	if err := c.client.Set(ctx, labelCacheKey(hash), raw, ttl).Err(); err != nil {
+		log.Println("error writing label cache entry:", err)
+	}
+}
+
+func labelCacheKey(hash string) string {
+	return "mashroom:labels:" + hash
+}
+
+type lruLabelCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	hash      string
+	labels    []Candidate
+	expiresAt time.Time
+}
+
+func newLRULabelCache(capacity int) *lruLabelCache {
+	return &lruLabelCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *lruLabelCache) Get(ctx context.Context, hash string) ([]Candidate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[hash]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.ll.Remove(elem)
+		delete(c.items, hash)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.labels, true
+}
+
+func (c *lruLabelCache) Put(ctx context.Context, hash string, labels []Candidate, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[hash]; ok {
+		c.ll.MoveToFront(elem)
+		elem.Value.(*lruEntry).labels = labels
+		elem.Value.(*lruEntry).expiresAt = time.Now().Add(ttl)
+		return
+	}
+
+	elem := c.ll.PushFront(&lruEntry{hash: hash, labels: labels, expiresAt: time.Now().Add(ttl)})
+	c.items[hash] = elem
+
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*lruEntry).hash)
+		}
+	}
+}
+
+// hashBytes returns the hex-encoded SHA-256 hash of data, used as the
+// LabelCache key for a downloaded image.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	vision "cloud.google.com/go/vision/apiv1"
+	visionpb "google.golang.org/genproto/googleapis/cloud/vision/v1"
+)
+
+// Candidate is a single ranked species guess returned by a Classifier.
+type Candidate struct {
+	Name       string
+	Confidence float32
+}
+
+// Classifier identifies the mushroom species shown in an image, ranked by
+// confidence. Generic label detection only confirms that an image contains
+// a mushroom; a Classifier is what narrows that down to a species. The
+// already-fetched Annotation is passed in so classifiers that can work from
+// it (VisionLabelClassifier) don't need their own Vision call.
+type Classifier interface {
+	Classify(ctx context.Context, data []byte, annotation *Annotation) ([]Candidate, error)
+}
+
+// VisionLabelClassifier treats the most specific-looking Vision labels as
+// species candidates. It's the default, lowest-effort classifier and is
+// used as a fallback when no product set is configured or Product Search
+// finds no match. It reuses the Annotation already produced by AnnotateImage
+// for the same photo, so it makes no Vision call of its own.
+type VisionLabelClassifier struct{}
+
+// Classify returns every non-generic label in annotation as a candidate,
+// keeping its Vision label score as the confidence.
+func (VisionLabelClassifier) Classify(ctx context.Context, data []byte, annotation *Annotation) ([]Candidate, error) {
+	var candidates []Candidate
+	for _, label := range annotation.Labels {
+		if isGenericMushroomLabel(label.Name) {
+			continue
+		}
+		candidates = append(candidates, label)
+	}
+	sortCandidates(candidates)
+	return candidates, nil
+}
+
+// ProductSearchClassifier matches an image against a curated mushroom
+// reference set using Vision Product Search, configured via the PRODUCT_SET
+// env var (e.g. "projects/P/locations/L/productSets/S").
+type ProductSearchClassifier struct {
+	ProductSet string
+}
+
+// NewProductSearchClassifier builds a ProductSearchClassifier from the
+// PRODUCT_SET env var. It returns an error if the env var is unset, since
+// there's no sane default reference set.
+func NewProductSearchClassifier() (*ProductSearchClassifier, error) {
+	productSet := os.Getenv("PRODUCT_SET")
+	if productSet == "" {
+		return nil, fmt.Errorf("PRODUCT_SET is not configured")
+	}
+	return &ProductSearchClassifier{ProductSet: productSet}, nil
+}
+
+// Classify runs a Product Search request against c.ProductSet and returns
+// matching products ranked by score. The caller should fall back to
+// VisionLabelClassifier when this returns no candidates. Unlike
+// VisionLabelClassifier, this issues its own Vision call, since Product
+// Search results aren't part of the Annotation from AnnotateImage.
+func (c *ProductSearchClassifier) Classify(ctx context.Context, data []byte, annotation *Annotation) ([]Candidate, error) {
+	client, err := vision.NewImageAnnotatorClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	image, err := vision.NewImageFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req := &visionpb.AnnotateImageRequest{
+		Image: image,
+		Features: []*visionpb.Feature{
+			{Type: visionpb.Feature_PRODUCT_SEARCH},
+		},
+		ImageContext: &visionpb.ImageContext{
+			ProductSearchParams: &visionpb.ProductSearchParams{
+				ProductSet: c.ProductSet,
+			},
+		},
+	}
+
+	resp, err := client.BatchAnnotateImages(ctx, &visionpb.BatchAnnotateImagesRequest{
+		Requests: []*visionpb.AnnotateImageRequest{req},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Responses) == 0 {
+		return nil, nil
+	}
+	if err := resp.Responses[0].GetError(); err != nil {
+		return nil, fmt.Errorf("product search: %s", err.GetMessage())
+	}
+
+	results := resp.Responses[0].GetProductSearchResults().GetResults()
+	candidates := make([]Candidate, 0, len(results))
+	for _, result := range results {
+		candidates = append(candidates, Candidate{
+			Name:       result.GetProduct().GetDisplayName(),
+			Confidence: result.GetScore(),
+		})
+	}
+	sortCandidates(candidates)
+	return candidates, nil
+}
+
+func sortCandidates(candidates []Candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+}
+
+// genericLabelStoplist holds Vision labels that describe the scene rather
+// than a species — generic fungus/mushroom terms plus the nature/scene tags
+// Vision commonly returns alongside them (e.g. "Natural environment",
+// "Terrestrial plant"). VisionLabelClassifier excludes these so they don't
+// get shown to the user as ranked species candidates.
+var genericLabelStoplist = []string{
+	"fungus",
+	"mushroom",
+	"natural environment",
+	"natural landscape",
+	"terrestrial plant",
+	"organism",
+	"plant",
+	"flowering plant",
+	"woody plant",
+	"vegetation",
+	"groundcover",
+	"wood",
+	"tree",
+	"grass",
+	"grassland",
+	"soil",
+	"forest",
+	"leaf",
+}
+
+func isGenericMushroomLabel(label string) bool {
+	return hasAny(genericLabelStoplist, []string{label})
+}
+
+// candidateNames extracts the plain names from a slice of Candidate, e.g.
+// to run generic string matching (hasAny, filter) over Vision labels.
+func candidateNames(candidates []Candidate) []string {
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// formatCandidates renders ranked candidates as the numbered list shown to
+// the user, e.g. "1. Boletus edulis — 87%".
+func formatCandidates(candidates []Candidate) string {
+	text := ""
+	for i, c := range candidates {
+		text += fmt.Sprintf("%d. %s — %.0f%%\n", i+1, c.Name, c.Confidence*100)
+	}
+	return text
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	bot "github.com/meinside/telegram-bot-go"
+)
+
+// CommandHandler handles a single bot command and returns the text to send
+// back to the chat.
+type CommandHandler func(ctx context.Context, webhook bot.Update, args []string) (string, error)
+
+// Router dispatches incoming text messages to registered command handlers
+// keyed by their `/command` name (without the leading slash).
+type Router struct {
+	handlers map[string]CommandHandler
+}
+
+// NewRouter returns an empty Router ready for handler registration.
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]CommandHandler)}
+}
+
+// Handle registers handler for the given command name, e.g. "start" for
+// "/start".
+func (r *Router) Handle(command string, handler CommandHandler) {
+	r.handlers[command] = handler
+}
+
+// Lookup returns the handler registered for command, if any. command must
+// already have the leading slash and optional "@botname" suffix stripped.
+func (r *Router) Lookup(command string) (CommandHandler, bool) {
+	handler, ok := r.handlers[command]
+	return handler, ok
+}
+
+func handleStart(ctx context.Context, webhook bot.Update, args []string) (string, error) {
+	return "Привет! Пришлите мне фотографию гриба, и я попробую его распознать.\n" +
+		"Список команд: /help" + messageAppendix, nil
+}
+
+func handleHelp(ctx context.Context, webhook bot.Update, args []string) (string, error) {
+	return "Доступные команды:\n" +
+		"/start — начать работу с ботом\n" +
+		"/help — это сообщение\n" +
+		"/groupid — узнать ID текущего чата\n" +
+		"/lang — узнать язык ответов\n" +
+		"/disclaimer — предупреждение об ответственности\n" +
+		"/history — показать историю переписки\n" +
+		"/reset — очистить историю переписки", nil
+}
+
+func handleGroupID(ctx context.Context, webhook bot.Update, args []string) (string, error) {
+	return fmt.Sprintf("ID этого чата: %d", webhook.Message.Chat.ID), nil
+}
+
+func handleLang(ctx context.Context, webhook bot.Update, args []string) (string, error) {
+	return fmt.Sprintf("Язык ответов: %s", targetLanguage), nil
+}
+
+func handleDisclaimer(ctx context.Context, webhook bot.Update, args []string) (string, error) {
+	return messageAppendix, nil
+}
+
+func handleHistory(ctx context.Context, webhook bot.Update, args []string) (string, error) {
+	chatID := strconv.FormatInt(webhook.Message.Chat.ID, 10)
+	history, err := sessionStore.History(ctx, chatID)
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		return "История переписки пуста.", nil
+	}
+	var lines []string
+	for _, turn := range history {
+		lines = append(lines, "— "+turn.Text)
+	}
+	return "Последние сообщения:\n" + strings.Join(lines, "\n"), nil
+}
+
+func handleReset(ctx context.Context, webhook bot.Update, args []string) (string, error) {
+	chatID := strconv.FormatInt(webhook.Message.Chat.ID, 10)
+	if err := sessionStore.Reset(ctx, chatID); err != nil {
+		return "", err
+	}
+	return "История переписки очищена.", nil
+}
+
+// parseCommand extracts the command name (without "/" or an "@botname"
+// suffix) and the remaining whitespace-separated args from a message's text.
+// It returns ok=false if text does not start with a command.
+func parseCommand(text string) (command string, args []string, ok bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil, false
+	}
+	command = strings.TrimPrefix(fields[0], "/")
+	if at := strings.IndexByte(command, '@'); at >= 0 {
+		command = command[:at]
+	}
+	if command == "" {
+		return "", nil, false
+	}
+	return command, fields[1:], true
+}
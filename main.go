@@ -5,19 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
 	dialogflow "cloud.google.com/go/dialogflow/apiv2"
-	translate "cloud.google.com/go/translate/apiv3"
 	vision "cloud.google.com/go/vision/apiv1"
 	bot "github.com/meinside/telegram-bot-go"
 	"golang.org/x/oauth2/google"
 	dialogflowpb "google.golang.org/genproto/googleapis/cloud/dialogflow/v2"
-	translatepb "google.golang.org/genproto/googleapis/cloud/translate/v3"
 )
 
 const (
@@ -26,12 +26,41 @@ const (
 )
 
 var botToken string
+var router *Router
+var labelCache LabelCache
+var speciesCache LabelCache
+var defaultClassifier Classifier = VisionLabelClassifier{}
+var classifier Classifier = defaultClassifier
+var sessionStore SessionStore
 
 func init() {
 	botToken = os.Getenv("BOT_TOKEN")
+	labelCache = newLabelCache()
+	speciesCache = newLabelCache()
+	sessionStore = newSessionStore()
+	if psc, err := NewProductSearchClassifier(); err == nil {
+		classifier = psc
+	}
+	router = NewRouter()
+	router.Handle("start", handleStart)
+	router.Handle("help", handleHelp)
+	router.Handle("groupid", handleGroupID)
+	router.Handle("lang", handleLang)
+	router.Handle("disclaimer", handleDisclaimer)
+	router.Handle("history", handleHistory)
+	router.Handle("reset", handleReset)
 }
 
 func main() {
+	mode := os.Getenv("MASHROOM_MODE")
+	if mode == "polling" {
+		runPolling()
+		return
+	}
+	runWebhook()
+}
+
+func runWebhook() {
 	log.Print("starting server...")
 	http.HandleFunc("/", handler)
 
@@ -64,24 +93,24 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if webhook.Message.HasText() {
-		log.Printf("got webhook with text")
-		err := processText(ctx, webhook)
-		if err != nil {
-			log.Println("error processing text message:", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
+	if err := dispatch(ctx, webhook); err != nil {
+		log.Println("error dispatching update:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+}
 
-	log.Printf("got webhook with photo")
-	err = processPhoto(ctx, webhook)
-	if err != nil {
-		log.Println("error processing message with photo:", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+// dispatch routes an already-validated update to the text or photo handler.
+// It is shared by the webhook and polling modes so both process updates
+// through the same logic.
+func dispatch(ctx context.Context, update bot.Update) error {
+	if update.Message.HasText() {
+		log.Printf("got update with text")
+		return processText(ctx, update)
 	}
+
+	log.Printf("got update with photo")
+	return processPhoto(ctx, update)
 }
 
 func parseWebhook(r *http.Request) (bot.Update, error) {
@@ -102,19 +131,48 @@ func validateWebhook(webhook bot.Update) error {
 }
 
 func processText(ctx context.Context, webhook bot.Update) error {
+	if command, args, ok := parseCommand(*webhook.Message.Text); ok {
+		if handler, ok := router.Lookup(command); ok {
+			reply, err := handler(ctx, webhook, args)
+			if err != nil {
+				return err
+			}
+			client := bot.NewClient(botToken)
+			sent := client.SendMessage(
+				webhook.Message.Chat.ID,
+				reply,
+				bot.OptionsSendMessage{},
+			)
+			if !sent.Ok {
+				return fmt.Errorf("send message: %s", *sent.Description)
+			}
+			return nil
+		}
+	}
+
 	creds, err := google.FindDefaultCredentials(ctx)
 	if err != nil {
 		return err
 	}
-	replies, err := DetectIntentText(
+	chatID := strconv.FormatInt(webhook.Message.Chat.ID, 10)
+	history, err := sessionStore.History(ctx, chatID)
+	if err != nil {
+		log.Println("error loading session history:", err)
+	}
+	text := *webhook.Message.Text
+	replies, intent, err := DetectIntentText(
 		creds.ProjectID,
-		strconv.FormatInt(webhook.Message.Chat.ID, 10),
-		*webhook.Message.Text,
+		chatID,
+		text,
 		targetLanguage,
+		history,
 	)
 	if err != nil {
 		return err
 	}
+	if err := sessionStore.Append(ctx, chatID, Turn{Text: text, Intent: intent}, defaultSessionTTL); err != nil {
+		log.Println("error saving session turn:", err)
+	}
 	client := bot.NewClient(botToken)
 	for _, reply := range replies {
 		sent := client.SendMessage(
@@ -134,11 +192,53 @@ func processPhoto(ctx context.Context, webhook bot.Update) error {
 	if err != nil {
 		return fmt.Errorf("error getting file URL: %v", err)
 	}
-	labels, err := DetectLabels(url)
+	resp, err := http.Get(url)
 	if err != nil {
-		return fmt.Errorf("error detecting labels: %v", err)
+		return fmt.Errorf("error downloading photo: %v", err)
 	}
-	if !hasAny([]string{"fungus", "mushroom"}, labels) {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading photo: %v", err)
+	}
+
+	hash := hashBytes(data)
+	labels, hit := labelCache.Get(ctx, hash)
+	var annotation *Annotation
+	if !hit {
+		client, err := vision.NewImageAnnotatorClient(ctx)
+		if err != nil {
+			return fmt.Errorf("error creating vision client: %v", err)
+		}
+		defer client.Close()
+
+		annotation, err = AnnotateImage(ctx, client, data)
+		if err != nil {
+			return fmt.Errorf("error annotating image: %v", err)
+		}
+		if !annotation.Safe {
+			client := bot.NewClient(botToken)
+			sent := client.SendMessage(
+				webhook.Message.Chat.ID,
+				"Это изображение не прошло модерацию, поэтому я не могу его обработать."+messageAppendix,
+				bot.OptionsSendMessage{}.
+					SetReplyToMessageID(webhook.Message.MessageID), // show original message
+			)
+			if !sent.Ok {
+				return fmt.Errorf("send message: %s", *sent.Description)
+			}
+			return nil
+		}
+		labels = annotation.Labels
+		labelCache.Put(ctx, hash, labels, defaultLabelCacheTTL)
+	} else {
+		// A cache hit means this image was already vetted by SafeSearch
+		// the first time it was seen.
+		annotation = &Annotation{Labels: labels, Safe: true}
+	}
+
+	labelNames := candidateNames(labels)
+	if !hasAny([]string{"fungus", "mushroom"}, labelNames) {
 		client := bot.NewClient(botToken)
 		sent := client.SendMessage(
 			webhook.Message.Chat.ID,
@@ -151,13 +251,46 @@ func processPhoto(ctx context.Context, webhook bot.Update) error {
 		}
 		return nil
 	}
-	labels = filter(labels, []string{"fungus", "mushroom"})
-	text := strings.Join(labels, ", ")
-	//text, err = translateText(ctx, text)
-	//if err != nil {
-	//	// log error, send message with untranslated text
-	//	log.Println("error translating text:", err)
-	//}
+	labelNames = filter(labelNames, []string{"fungus", "mushroom"})
+
+	speciesKey := hash + ":species"
+	candidates, hit := speciesCache.Get(ctx, speciesKey)
+	if !hit {
+		var err error
+		candidates, err = classifier.Classify(ctx, data, annotation)
+		if err != nil {
+			log.Println("error classifying species:", err)
+		}
+		if len(candidates) == 0 && classifier != defaultClassifier {
+			candidates, err = defaultClassifier.Classify(ctx, data, annotation)
+			if err != nil {
+				log.Println("error classifying species with fallback classifier:", err)
+			}
+		}
+		if len(candidates) > 0 {
+			speciesCache.Put(ctx, speciesKey, candidates, defaultLabelCacheTTL)
+		}
+	}
+
+	var text string
+	if len(candidates) > 0 {
+		if translationEnabled {
+			candidates = translateCandidates(ctx, candidates)
+		}
+		text = formatCandidates(candidates)
+	} else {
+		text = strings.Join(labelNames, ", ")
+		if translationEnabled {
+			translated, err := translateText(ctx, text)
+			if err != nil {
+				// log error, send message with untranslated text
+				log.Println("error translating text:", err)
+			} else {
+				text = translated
+			}
+		}
+	}
+
 	client := bot.NewClient(botToken)
 	sent := client.SendMessage(
 		webhook.Message.Chat.ID,
@@ -171,28 +304,36 @@ func processPhoto(ctx context.Context, webhook bot.Update) error {
 	return nil
 }
 
-func DetectIntentText(projectID, sessionID, text, languageCode string) ([]string, error) {
+// DetectIntentText sends text to Dialogflow as sessionID's session, carrying
+// history as query contexts so follow-up intents can resolve. It returns the
+// fulfillment messages to send back and the display name of the intent that
+// was detected, for the caller to record in a SessionStore.
+func DetectIntentText(projectID, sessionID, text, languageCode string, history []Turn) ([]string, string, error) {
 	ctx := context.Background()
 
 	sessionClient, err := dialogflow.NewSessionsClient(ctx)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer sessionClient.Close()
 
 	if projectID == "" || sessionID == "" {
-		return nil, errors.New(fmt.Sprintf("Received empty project (%s) or session (%s)", projectID, sessionID))
+		return nil, "", errors.New(fmt.Sprintf("Received empty project (%s) or session (%s)", projectID, sessionID))
 	}
 
 	sessionPath := fmt.Sprintf("projects/%s/agent/sessions/%s", projectID, sessionID)
 	textInput := dialogflowpb.TextInput{Text: text, LanguageCode: languageCode}
 	queryTextInput := dialogflowpb.QueryInput_Text{Text: &textInput}
 	queryInput := dialogflowpb.QueryInput{Input: &queryTextInput}
-	request := dialogflowpb.DetectIntentRequest{Session: sessionPath, QueryInput: &queryInput}
+	request := dialogflowpb.DetectIntentRequest{
+		Session:     sessionPath,
+		QueryInput:  &queryInput,
+		QueryParams: &dialogflowpb.QueryParameters{Contexts: historyContexts(sessionPath, history)},
+	}
 
 	response, err := sessionClient.DetectIntent(ctx, &request)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	queryResult := response.GetQueryResult()
@@ -204,7 +345,38 @@ func DetectIntentText(projectID, sessionID, text, languageCode string) ([]string
 		}
 		out = append(out, msg.GetText().Text...)
 	}
-	return out, nil
+	return out, queryResult.GetIntent().GetDisplayName(), nil
+}
+
+// historyContexts turns prior turns into Dialogflow input contexts, one per
+// previously detected intent, so the agent can resolve follow-up intents
+// about a mushroom photo sent earlier in the conversation.
+func historyContexts(sessionPath string, history []Turn) []*dialogflowpb.Context {
+	var contexts []*dialogflowpb.Context
+	for _, turn := range history {
+		contextID := slugifyContextID(turn.Intent)
+		if contextID == "" {
+			continue
+		}
+		contexts = append(contexts, &dialogflowpb.Context{
+			Name:          fmt.Sprintf("%s/contexts/%s", sessionPath, contextID),
+			LifespanCount: 1,
+		})
+	}
+	return contexts
+}
+
+// contextIDPattern matches runs of characters that Dialogflow does not allow
+// in a context resource's ID segment (display names like "Default Welcome
+// Intent" are free text and don't qualify as IDs as they are).
+var contextIDPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// slugifyContextID turns an intent display name into a valid Dialogflow
+// context ID by lower-casing it and replacing every run of disallowed
+// characters with "-".
+func slugifyContextID(name string) string {
+	slug := contextIDPattern.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(slug, "-")
 }
 
 func fileURL(fileID string) (string, error) {
@@ -216,37 +388,6 @@ func fileURL(fileID string) (string, error) {
 	return fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", botToken, *file.Result.FilePath), nil
 }
 
-func DetectLabels(url string) ([]string, error) {
-	ctx := context.Background()
-
-	client, err := vision.NewImageAnnotatorClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	image, err := vision.NewImageFromReader(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	annotations, err := client.DetectLabels(ctx, image, nil, 10)
-	if err != nil {
-		return nil, err
-	}
-
-	labels := make([]string, len(annotations))
-	for i, annotation := range annotations {
-		labels[i] = annotation.Description
-	}
-
-	return labels, nil
-}
-
 func hasAny(what []string, where []string) bool {
 	for _, s1 := range what {
 		s1 = strings.ToLower(s1)
@@ -277,34 +418,3 @@ func filter(a, b []string) []string {
 	}
 	return c
 }
-
-func translateText(ctx context.Context, text string) (string, error) {
-	creds, err := google.FindDefaultCredentials(ctx)
-	if err != nil {
-		return "", err
-	}
-	client, err := translate.NewTranslationClient(ctx)
-	if err != nil {
-		return "", err
-	}
-	defer client.Close()
-
-	const sourceLanguage = "en-US"
-	req := &translatepb.TranslateTextRequest{
-		Parent:             fmt.Sprintf("projects/%s/locations/global", creds.ProjectID),
-		SourceLanguageCode: sourceLanguage,
-		TargetLanguageCode: targetLanguage,
-		MimeType:           "text/plain", // Mime types: "text/plain", "text/html"
-		Contents:           []string{text},
-	}
-	resp, err := client.TranslateText(ctx, req)
-	if err != nil {
-		return "", err
-	}
-	translations := resp.GetTranslations()
-	out := make([]string, len(translations))
-	for i, translation := range translations {
-		out[i] = translation.GetTranslatedText()
-	}
-	return strings.Join(out, ", "), nil
-}
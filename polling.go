@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	bot "github.com/meinside/telegram-bot-go"
+)
+
+// defaultPollTimeout is the long-poll timeout (in seconds) passed to
+// getUpdates when POLL_TIMEOUT is not set.
+const defaultPollTimeout = 60
+
+// runPolling runs the bot in Telegram long-polling mode, dispatching each
+// update through the same dispatch logic as the webhook handler. It returns
+// promptly on SIGTERM/SIGINT instead of waiting out the in-flight long poll
+// or error backoff; any update already being dispatched is left to finish
+// in the background.
+func runPolling() {
+	log.Print("starting in polling mode...")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-sigCh
+		log.Print("shutting down...")
+		cancel()
+	}()
+
+	client := bot.NewClient(botToken)
+	timeout := defaultPollTimeout
+	if v := os.Getenv("POLL_TIMEOUT"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			timeout = parsed
+		}
+	}
+	allowedUpdates := []string{"message"}
+	if v := os.Getenv("ALLOWED_UPDATES"); v != "" {
+		allowedUpdates = strings.Split(v, ",")
+	}
+
+	var offset int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// Run the long-poll (and the updates it yields) on its own
+		// goroutine so ctx cancellation can interrupt the wait instead of
+		// blocking for up to the full POLL_TIMEOUT.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			resp := client.GetUpdates(bot.OptionsGetUpdates{}.
+				SetOffset(offset).
+				SetTimeout(timeout).
+				SetAllowedUpdates(allowedUpdates))
+			if !resp.Ok {
+				log.Println("error getting updates:", *resp.Description)
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			for _, update := range resp.Result {
+				offset = update.UpdateID + 1
+				if err := validateWebhook(update); err != nil {
+					log.Println("error validating update:", err)
+					continue
+				}
+				if err := dispatch(ctx, update); err != nil {
+					log.Println("error dispatching update:", err)
+				}
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultSessionHistoryLimit is the number of past turns kept per chat.
+const defaultSessionHistoryLimit = 10
+
+// defaultSessionTTL is how long a chat's history survives without activity.
+const defaultSessionTTL = 24 * time.Hour
+
+// Turn is one exchange recorded by a SessionStore: the user's message and
+// the intent Dialogflow detected for it.
+type Turn struct {
+	Text   string `json:"text"`
+	Intent string `json:"intent"`
+}
+
+// SessionStore records the last N turns per chat so Dialogflow follow-up
+// intents keep working across restarts and agent switches.
+type SessionStore interface {
+	History(ctx context.Context, chatID string) ([]Turn, error)
+	Append(ctx context.Context, chatID string, turn Turn, ttl time.Duration) error
+	Reset(ctx context.Context, chatID string) error
+}
+
+// newSessionStore builds a SessionStore backed by Redis when REDIS_URL is
+// set, falling back to an in-memory store otherwise.
+func newSessionStore() SessionStore {
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		opt, err := redis.ParseURL(url)
+		if err != nil {
+			log.Printf("error parsing REDIS_URL, falling back to in-memory session store: %v", err)
+			return newMemorySessionStore()
+		}
+		return &redisSessionStore{client: redis.NewClient(opt)}
+	}
+	return newMemorySessionStore()
+}
+
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+func (s *redisSessionStore) History(ctx context.Context, chatID string) ([]Turn, error) {
+	raw, err := s.client.Get(ctx, sessionKey(chatID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var turns []Turn
+	if err := json.Unmarshal([]byte(raw), &turns); err != nil {
+		return nil, err
+	}
+	return turns, nil
+}
+
+func (s *redisSessionStore) Append(ctx context.Context, chatID string, turn Turn, ttl time.Duration) error {
+	turns, err := s.History(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	turns = appendTurn(turns, turn)
+	raw, err := json.Marshal(turns)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, sessionKey(chatID), raw, ttl).Err()
+}
+
+func (s *redisSessionStore) Reset(ctx context.Context, chatID string) error {
+	return s.client.Del(ctx, sessionKey(chatID)).Err()
+}
+
+func sessionKey(chatID string) string {
+	return "mashroom:session:" + chatID
+}
+
+type memorySessionEntry struct {
+	turns     []Turn
+	expiresAt time.Time
+}
+
+type memorySessionStore struct {
+	mu      sync.Mutex
+	entries map[string]*memorySessionEntry
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{entries: make(map[string]*memorySessionEntry)}
+}
+
+func (s *memorySessionStore) History(ctx context.Context, chatID string) ([]Turn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[chatID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return entry.turns, nil
+}
+
+func (s *memorySessionStore) Append(ctx context.Context, chatID string, turn Turn, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[chatID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		entry = &memorySessionEntry{}
+		s.entries[chatID] = entry
+	}
+	entry.turns = appendTurn(entry.turns, turn)
+	entry.expiresAt = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *memorySessionStore) Reset(ctx context.Context, chatID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, chatID)
+	return nil
+}
+
+func appendTurn(turns []Turn, turn Turn) []Turn {
+	turns = append(turns, turn)
+	if len(turns) > defaultSessionHistoryLimit {
+		turns = turns[len(turns)-defaultSessionHistoryLimit:]
+	}
+	return turns
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	translate "cloud.google.com/go/translate/apiv3"
+	"golang.org/x/oauth2/google"
+	translatepb "google.golang.org/genproto/googleapis/cloud/translate/v3"
+)
+
+// translationEnabled gates label translation: it is off by default and is
+// turned on by setting ENABLE_TRANSLATION=true. A glossary is used only when
+// GLOSSARY_URI is also set; otherwise translation runs without one.
+var translationEnabled = os.Getenv("ENABLE_TRANSLATION") == "true"
+
+// translator is the package-level Translator singleton, built once in
+// init() like labelCache/classifier/sessionStore, so a translated message
+// doesn't pay for a new TranslationClient or a glossary lookup/creation on
+// every request. It stays nil if translation is disabled or setup fails.
+var translator *Translator
+
+func init() {
+	if !translationEnabled {
+		return
+	}
+
+	ctx := context.Background()
+	creds, err := google.FindDefaultCredentials(ctx)
+	if err != nil {
+		log.Println("error finding default credentials for translator:", err)
+		return
+	}
+	t, err := NewTranslator(ctx, creds.ProjectID)
+	if err != nil {
+		log.Println("error creating translator:", err)
+		return
+	}
+	if uri := os.Getenv("GLOSSARY_URI"); uri != "" {
+		if err := t.LoadGlossary(ctx, uri); err != nil {
+			log.Println("error loading glossary:", err)
+		}
+	}
+	translator = t
+}
+
+// Translator translates mushroom label terms to Russian using a Cloud
+// Translation v3 glossary of English→Russian mushroom names, so that
+// species names come out correct rather than translated word-for-word.
+type Translator struct {
+	client       *translate.TranslationClient
+	projectID    string
+	glossaryPath string
+}
+
+// NewTranslator creates a Translator for the given GCP project. Call
+// LoadGlossary before Translate to enable glossary-aware translation.
+func NewTranslator(ctx context.Context, projectID string) (*Translator, error) {
+	client, err := translate.NewTranslationClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Translator{client: client, projectID: projectID}, nil
+}
+
+// LoadGlossary registers a glossary with Cloud Translation from a CSV file
+// stored in GCS at uri (format: "gs://bucket/object"), where each row is
+// "english term,russian term". The glossary is created if it does not
+// already exist, keyed by the object's base name.
+func (t *Translator) LoadGlossary(ctx context.Context, uri string) error {
+	glossaryID := glossaryIDFromURI(uri)
+	glossaryPath := fmt.Sprintf("projects/%s/locations/global/glossaries/%s", t.projectID, glossaryID)
+
+	_, err := t.client.GetGlossary(ctx, &translatepb.GetGlossaryRequest{Name: glossaryPath})
+	if err == nil {
+		t.glossaryPath = glossaryPath
+		return nil
+	}
+
+	op, err := t.client.CreateGlossary(ctx, &translatepb.CreateGlossaryRequest{
+		Parent: fmt.Sprintf("projects/%s/locations/global", t.projectID),
+		Glossary: &translatepb.Glossary{
+			Name: glossaryPath,
+			LanguagePair: &translatepb.Glossary_LanguagePair{
+				LanguagePair: &translatepb.Glossary_LanguageCodePair{
+					SourceLanguageCode: "en",
+					TargetLanguageCode: "ru",
+				},
+			},
+			InputConfig: &translatepb.GlossaryInputConfig{
+				Source: &translatepb.GlossaryInputConfig_GcsSource{
+					GcsSource: &translatepb.GcsSource{InputUri: uri},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating glossary: %v", err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("error waiting for glossary creation: %v", err)
+	}
+
+	t.glossaryPath = glossaryPath
+	return nil
+}
+
+// Translate translates all terms in a single batched request, using the
+// loaded glossary when available. On error the caller is responsible for
+// falling back to the original terms.
+func (t *Translator) Translate(ctx context.Context, terms []string) ([]string, error) {
+	req := &translatepb.TranslateTextRequest{
+		Parent:             fmt.Sprintf("projects/%s/locations/global", t.projectID),
+		SourceLanguageCode: "en",
+		TargetLanguageCode: "ru",
+		MimeType:           "text/plain",
+		Contents:           terms,
+	}
+	if t.glossaryPath != "" {
+		req.GlossaryConfig = &translatepb.TranslateTextGlossaryConfig{Glossary: t.glossaryPath}
+	}
+
+	resp, err := t.client.TranslateText(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	translations := resp.GetGlossaryTranslations()
+	if len(translations) == 0 {
+		translations = resp.GetTranslations()
+	}
+	for _, translation := range translations {
+		out = append(out, translation.GetTranslatedText())
+	}
+	return out, nil
+}
+
+func glossaryIDFromURI(uri string) string {
+	base := uri[strings.LastIndex(uri, "/")+1:]
+	return strings.TrimSuffix(base, ".csv")
+}
+
+// translateText translates a comma-joined label string using the shared
+// translator. On error, or if translation isn't configured, it returns text
+// unchanged so the caller can still show something to the user.
+func translateText(ctx context.Context, text string) (string, error) {
+	if translator == nil {
+		return text, fmt.Errorf("translator is not configured")
+	}
+	translated, err := translator.Translate(ctx, strings.Split(text, ", "))
+	if err != nil {
+		return text, err
+	}
+	return strings.Join(translated, ", "), nil
+}
+
+// translateTerms translates a batch of terms using the shared translator,
+// preserving order so callers can re-associate each translation with
+// whatever else (e.g. a Candidate's confidence) came with the original
+// term. On error, or if translation isn't configured, it returns terms
+// unchanged.
+func translateTerms(ctx context.Context, terms []string) ([]string, error) {
+	if translator == nil {
+		return terms, fmt.Errorf("translator is not configured")
+	}
+	translated, err := translator.Translate(ctx, terms)
+	if err != nil {
+		return terms, err
+	}
+	return translated, nil
+}
+
+// translateCandidates translates each Candidate's Name via translateTerms,
+// keeping its Confidence, so a translated species list can still be ranked
+// and formatted the same way as the original. On translation error it logs
+// and returns candidates unchanged.
+func translateCandidates(ctx context.Context, candidates []Candidate) []Candidate {
+	names := candidateNames(candidates)
+	translated, err := translateTerms(ctx, names)
+	if err != nil {
+		log.Println("error translating species names:", err)
+		return candidates
+	}
+	if len(translated) != len(candidates) {
+		log.Println("error translating species names: unexpected translation count")
+		return candidates
+	}
+
+	out := make([]Candidate, len(candidates))
+	for i, c := range candidates {
+		out[i] = Candidate{Name: translated[i], Confidence: c.Confidence}
+	}
+	return out
+}
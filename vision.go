@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	vision "cloud.google.com/go/vision/apiv1"
+	gax "github.com/googleapis/gax-go/v2"
+	visionpb "google.golang.org/genproto/googleapis/cloud/vision/v1"
+)
+
+// ImageAnnotatorClient is the subset of *vision.ImageAnnotatorClient that
+// AnnotateImage depends on, extracted so the photo pipeline can be tested
+// against a fake instead of a live Vision client.
+type ImageAnnotatorClient interface {
+	BatchAnnotateImages(ctx context.Context, req *visionpb.BatchAnnotateImagesRequest, opts ...gax.CallOption) (*visionpb.BatchAnnotateImagesResponse, error)
+}
+
+// Annotation is the result of running label detection and SafeSearch
+// moderation on an image in a single Vision API call. Labels keeps each
+// label's confidence score so callers (e.g. a Classifier) don't need a
+// second Vision call just to get scores.
+type Annotation struct {
+	Labels []Candidate
+	Safe   bool
+}
+
+// unsafeLikelihood is the SafeSearch likelihood at and above which an image
+// is rejected.
+const unsafeLikelihood = visionpb.Likelihood_LIKELY
+
+// AnnotateImage runs label detection and SafeSearch moderation on data in a
+// single BatchAnnotateImagesRequest, so the caller pays for one Vision API
+// call instead of two.
+func AnnotateImage(ctx context.Context, client ImageAnnotatorClient, data []byte) (*Annotation, error) {
+	image, err := vision.NewImageFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.BatchAnnotateImages(ctx, &visionpb.BatchAnnotateImagesRequest{
+		Requests: []*visionpb.AnnotateImageRequest{
+			{
+				Image: image,
+				Features: []*visionpb.Feature{
+					{Type: visionpb.Feature_LABEL_DETECTION, MaxResults: 10},
+					{Type: visionpb.Feature_SAFE_SEARCH_DETECTION},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Responses) == 0 {
+		return nil, fmt.Errorf("vision: empty response")
+	}
+
+	imageAnnotation := resp.Responses[0]
+	if imageAnnotation.Error != nil {
+		return nil, fmt.Errorf("vision: %s", imageAnnotation.Error.GetMessage())
+	}
+
+	labels := make([]Candidate, len(imageAnnotation.LabelAnnotations))
+	for i, label := range imageAnnotation.LabelAnnotations {
+		labels[i] = Candidate{Name: label.Description, Confidence: label.Score}
+	}
+
+	return &Annotation{
+		Labels: labels,
+		Safe:   isSafe(imageAnnotation.SafeSearchAnnotation),
+	}, nil
+}
+
+// isSafe reports whether a SafeSearch annotation is below the adult/violence
+// likelihood threshold that triggers a refusal.
+func isSafe(safeSearch *visionpb.SafeSearchAnnotation) bool {
+	if safeSearch == nil {
+		return true
+	}
+	return safeSearch.GetAdult() < unsafeLikelihood && safeSearch.GetViolence() < unsafeLikelihood
+}
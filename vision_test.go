@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	gax "github.com/googleapis/gax-go/v2"
+	visionpb "google.golang.org/genproto/googleapis/cloud/vision/v1"
+)
+
+type fakeImageAnnotatorClient struct {
+	resp *visionpb.BatchAnnotateImagesResponse
+	err  error
+}
+
+func (f *fakeImageAnnotatorClient) BatchAnnotateImages(ctx context.Context, req *visionpb.BatchAnnotateImagesRequest, opts ...gax.CallOption) (*visionpb.BatchAnnotateImagesResponse, error) {
+	return f.resp, f.err
+}
+
+func TestAnnotateImage_Safe(t *testing.T) {
+	client := &fakeImageAnnotatorClient{
+		resp: &visionpb.BatchAnnotateImagesResponse{
+			Responses: []*visionpb.AnnotateImageResponse{
+				{
+					LabelAnnotations: []*visionpb.EntityAnnotation{
+						{Description: "fungus"},
+						{Description: "chanterelle"},
+					},
+					SafeSearchAnnotation: &visionpb.SafeSearchAnnotation{
+						Adult:    visionpb.Likelihood_VERY_UNLIKELY,
+						Violence: visionpb.Likelihood_VERY_UNLIKELY,
+					},
+				},
+			},
+		},
+	}
+
+	annotation, err := AnnotateImage(context.Background(), client, []byte("fake-image-bytes"))
+	if err != nil {
+		t.Fatalf("AnnotateImage returned error: %v", err)
+	}
+	if !annotation.Safe {
+		t.Fatal("expected image to be marked safe")
+	}
+	if len(annotation.Labels) != 2 || annotation.Labels[1].Name != "chanterelle" {
+		t.Fatalf("unexpected labels: %v", annotation.Labels)
+	}
+}
+
+func TestAnnotateImage_Unsafe(t *testing.T) {
+	client := &fakeImageAnnotatorClient{
+		resp: &visionpb.BatchAnnotateImagesResponse{
+			Responses: []*visionpb.AnnotateImageResponse{
+				{
+					SafeSearchAnnotation: &visionpb.SafeSearchAnnotation{
+						Adult: visionpb.Likelihood_VERY_LIKELY,
+					},
+				},
+			},
+		},
+	}
+
+	annotation, err := AnnotateImage(context.Background(), client, []byte("fake-image-bytes"))
+	if err != nil {
+		t.Fatalf("AnnotateImage returned error: %v", err)
+	}
+	if annotation.Safe {
+		t.Fatal("expected image to be marked unsafe")
+	}
+}